@@ -0,0 +1,85 @@
+package boringproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CreateSshKey validates and stores an SSH public key owned by
+// tokenData.Owner, parsing it the same way the WebUI form does. It's the
+// single place both the WebUI and the REST API go through, so ownership
+// and validation can't drift between the two.
+func (a *Api) CreateSshKey(tokenData TokenData, params url.Values) error {
+	id := params.Get("id")
+	if id == "" {
+		return fmt.Errorf("id parameter is required")
+	}
+
+	rawKey := params.Get("key")
+	if rawKey == "" {
+		return fmt.Errorf("key parameter is required")
+	}
+
+	parsedKey, err := parseSshKey(rawKey)
+	if err != nil {
+		return err
+	}
+
+	return a.db.AddSshKey(id, SshKey{Owner: tokenData.Owner, Key: parsedKey})
+}
+
+// apiSshKeyRequest is the JSON body accepted by POST /api/ssh-keys.
+type apiSshKeyRequest struct {
+	Id  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// HandleApiSshKeys serves the REST ssh-key endpoints, meant to be
+// registered by the same router that already dispatches /api/tunnels and
+// /api/tokens to their Api methods: POST /api/ssh-keys adds a key owned
+// by tokenData.Owner, DELETE /api/ssh-keys/{id} removes one, scoped to
+// tokenData's owner (or any owner, for an admin token).
+func (a *Api) HandleApiSshKeys(w http.ResponseWriter, r *http.Request, tokenData TokenData) {
+	switch r.Method {
+	case http.MethodPost:
+		a.handleApiCreateSshKey(w, r, tokenData)
+	case http.MethodDelete:
+		a.handleApiDeleteSshKey(w, r, tokenData)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *Api) handleApiCreateSshKey(w http.ResponseWriter, r *http.Request, tokenData TokenData) {
+	var req apiSshKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	params := url.Values{"id": {req.Id}, "key": {req.Key}}
+	if err := a.CreateSshKey(tokenData, params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (a *Api) handleApiDeleteSshKey(w http.ResponseWriter, r *http.Request, tokenData TokenData) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/ssh-keys/")
+	if id == "" {
+		http.Error(w, "ssh key id is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.DeleteSshKey(tokenData, url.Values{"id": {id}}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package boringproxy
+
+import (
+	"sync"
+	"time"
+)
+
+// Auth holds server-wide authentication state: which access tokens are
+// currently valid, plus per-token TOTP lockout bookkeeping, so any login
+// path -- the WebUI today, a future CLI or API login tomorrow -- shares
+// the same rate limiting instead of each reimplementing its own.
+type Auth struct {
+	db *Database
+
+	mutex        sync.Mutex
+	totpFailures map[string][]time.Time
+}
+
+func NewAuth(db *Database) *Auth {
+	return &Auth{
+		db:           db,
+		totpFailures: make(map[string][]time.Time),
+	}
+}
+
+// Authorized reports whether token corresponds to a known, valid access
+// token.
+func (a *Auth) Authorized(token string) bool {
+	_, exists := a.db.GetTokenData(token)
+	return exists
+}
+
+// TotpLocked reports whether token has hit totpMaxAttempts failed TOTP
+// verifications within the last totpAttemptWindow.
+func (a *Auth) TotpLocked(token string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return len(a.recentTotpFailures(token)) >= totpMaxAttempts
+}
+
+// RecordTotpFailure notes a failed TOTP verification for token.
+func (a *Auth) RecordTotpFailure(token string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.totpFailures[token] = append(a.recentTotpFailures(token), time.Now())
+}
+
+// ResetTotpFailures clears token's failure history, e.g. after a
+// successful verification.
+func (a *Auth) ResetTotpFailures(token string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.totpFailures, token)
+}
+
+// recentTotpFailures prunes failures for token older than
+// totpAttemptWindow and returns what's left. Callers must hold a.mutex.
+func (a *Auth) recentTotpFailures(token string) []time.Time {
+	cutoff := time.Now().Add(-totpAttemptWindow)
+
+	fresh := a.totpFailures[token][:0]
+	for _, t := range a.totpFailures[token] {
+		if t.After(cutoff) {
+			fresh = append(fresh, t)
+		}
+	}
+	a.totpFailures[token] = fresh
+
+	return fresh
+}
@@ -0,0 +1,29 @@
+package boringproxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthTotpLockout(t *testing.T) {
+	a := &Auth{totpFailures: make(map[string][]time.Time)}
+
+	token := "sometoken"
+
+	for i := 0; i < totpMaxAttempts; i++ {
+		if a.TotpLocked(token) {
+			t.Fatalf("locked out after only %d failures", i)
+		}
+		a.RecordTotpFailure(token)
+	}
+
+	if !a.TotpLocked(token) {
+		t.Fatalf("not locked out after %d failures", totpMaxAttempts)
+	}
+
+	a.ResetTotpFailures(token)
+
+	if a.TotpLocked(token) {
+		t.Fatal("still locked out after ResetTotpFailures")
+	}
+}
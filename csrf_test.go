@@ -0,0 +1,87 @@
+package boringproxy
+
+import "testing"
+
+func TestCsrfManagerRoundTrip(t *testing.T) {
+	m, err := newCsrfManager()
+	if err != nil {
+		t.Fatalf("newCsrfManager: %v", err)
+	}
+
+	cookieValue, token, err := m.GenerateCookieValue()
+	if err != nil {
+		t.Fatalf("GenerateCookieValue: %v", err)
+	}
+
+	if !m.Verify(cookieValue, token) {
+		t.Fatal("Verify rejected a token matching its own cookie")
+	}
+
+	got, err := m.TokenFromCookie(cookieValue)
+	if err != nil {
+		t.Fatalf("TokenFromCookie: %v", err)
+	}
+	if got != token {
+		t.Fatalf("TokenFromCookie = %q, want %q", got, token)
+	}
+}
+
+func TestCsrfManagerRejectsTamperedCookie(t *testing.T) {
+	m, err := newCsrfManager()
+	if err != nil {
+		t.Fatalf("newCsrfManager: %v", err)
+	}
+
+	cookieValue, token, err := m.GenerateCookieValue()
+	if err != nil {
+		t.Fatalf("GenerateCookieValue: %v", err)
+	}
+
+	if _, err := m.TokenFromCookie(cookieValue + "tampered"); err == nil {
+		t.Fatal("TokenFromCookie accepted a tampered cookie")
+	}
+
+	if m.Verify(cookieValue+"tampered", token) {
+		t.Fatal("Verify accepted a tampered cookie")
+	}
+}
+
+func TestCsrfManagerRejectsMismatchedToken(t *testing.T) {
+	m, err := newCsrfManager()
+	if err != nil {
+		t.Fatalf("newCsrfManager: %v", err)
+	}
+
+	cookieValue, _, err := m.GenerateCookieValue()
+	if err != nil {
+		t.Fatalf("GenerateCookieValue: %v", err)
+	}
+
+	if m.Verify(cookieValue, "some-other-token") {
+		t.Fatal("Verify accepted a token that doesn't match the cookie")
+	}
+
+	if m.Verify("", "") {
+		t.Fatal("Verify accepted empty cookie and token")
+	}
+}
+
+func TestCsrfManagerDifferentSecretsRejectEachOther(t *testing.T) {
+	a, err := newCsrfManager()
+	if err != nil {
+		t.Fatalf("newCsrfManager: %v", err)
+	}
+	b, err := newCsrfManager()
+	if err != nil {
+		t.Fatalf("newCsrfManager: %v", err)
+	}
+
+	cookieValue, token, err := a.GenerateCookieValue()
+	if err != nil {
+		t.Fatalf("GenerateCookieValue: %v", err)
+	}
+
+	if b.Verify(cookieValue, token) {
+		t.Fatal("Verify accepted a cookie signed with a different manager's secret")
+	}
+}
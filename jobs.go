@@ -0,0 +1,229 @@
+package boringproxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	jobTTL          = 5 * time.Minute
+	jobHeartbeat    = 15 * time.Second
+	maxJobsPerOwner = 4
+)
+
+// JobStatus is the lifecycle state of a Job.
+type JobStatus string
+
+const (
+	JobRunning  JobStatus = "running"
+	JobDone     JobStatus = "done"
+	JobFailed   JobStatus = "failed"
+	JobCanceled JobStatus = "canceled"
+)
+
+// Event is a single Server-Sent Event emitted by a running Job, e.g.
+// {Name: "progress", Data: "allocating port"}.
+type Event struct {
+	Name string
+	Data string
+}
+
+// Job tracks a single long-running operation submitted through a
+// JobManager, along with the history of Events it has emitted so late
+// subscribers can catch up.
+type Job struct {
+	Id    string
+	Owner string
+	Kind  string
+
+	mutex     sync.Mutex
+	status    JobStatus
+	err       error
+	history   []Event
+	listeners map[chan Event]bool
+	done      chan struct{}
+	cancel    context.CancelFunc
+}
+
+func (j *Job) Status() JobStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.status
+}
+
+func (j *Job) Err() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.err
+}
+
+func (j *Job) emit(e Event) {
+	j.mutex.Lock()
+	j.history = append(j.history, e)
+	for ch := range j.listeners {
+		select {
+		case ch <- e:
+		default:
+			// Slow subscriber, drop the event rather than block the job.
+		}
+	}
+	j.mutex.Unlock()
+}
+
+// subscribe registers a new listener and returns it along with a replay
+// of every Event emitted so far, so a client connecting mid-job doesn't
+// miss earlier progress.
+func (j *Job) subscribe() (chan Event, []Event) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	ch := make(chan Event, 16)
+	j.listeners[ch] = true
+
+	return ch, append([]Event{}, j.history...)
+}
+
+func (j *Job) unsubscribe(ch chan Event) {
+	j.mutex.Lock()
+	delete(j.listeners, ch)
+	j.mutex.Unlock()
+}
+
+// JobManager runs arbitrary long-running operations in the background,
+// reports their progress as a stream of Events, and reaps finished jobs
+// after a TTL. It replaces the one-off pendingRequests channel map that
+// only handleCreateTunnel used to use.
+type JobManager struct {
+	mutex sync.Mutex
+	jobs  map[string]*Job
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{jobs: make(map[string]*Job)}
+}
+
+// Submit runs fn in a new goroutine, tracked as a Job owned by ownerId.
+// The job's lifetime is deliberately independent of the HTTP request
+// that submitted it: a request's context is canceled the instant its
+// handler returns, which happens as soon as the loading page is sent,
+// long before fn finishes, so binding the job to it would make every
+// job look canceled. fn is not passed a context and always runs to
+// completion; Cancel only changes the status recorded when it returns.
+func (jm *JobManager) Submit(ownerId, kind string, fn func(progress chan<- Event) error) (string, error) {
+	jm.mutex.Lock()
+
+	active := 0
+	for _, job := range jm.jobs {
+		if job.Owner == ownerId && job.Status() == JobRunning {
+			active++
+		}
+	}
+	if active >= maxJobsPerOwner {
+		jm.mutex.Unlock()
+		return "", fmt.Errorf("too many concurrent jobs for owner %s", ownerId)
+	}
+
+	id, err := genRandomCode(16)
+	if err != nil {
+		jm.mutex.Unlock()
+		return "", err
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+
+	job := &Job{
+		Id:        id,
+		Owner:     ownerId,
+		Kind:      kind,
+		status:    JobRunning,
+		listeners: make(map[chan Event]bool),
+		done:      make(chan struct{}),
+		cancel:    cancel,
+	}
+	jm.jobs[id] = job
+
+	jm.mutex.Unlock()
+
+	progress := make(chan Event, 16)
+
+	go func() {
+		for e := range progress {
+			job.emit(e)
+		}
+	}()
+
+	go func() {
+		fnErr := fn(progress)
+		close(progress)
+
+		job.mutex.Lock()
+		switch {
+		case jobCtx.Err() == context.Canceled:
+			job.status = JobCanceled
+		case fnErr != nil:
+			job.status = JobFailed
+			job.err = fnErr
+		default:
+			job.status = JobDone
+		}
+		finalStatus := job.status
+		job.mutex.Unlock()
+
+		job.emit(Event{Name: "done", Data: string(finalStatus)})
+		close(job.done)
+
+		time.AfterFunc(jobTTL, func() {
+			jm.mutex.Lock()
+			delete(jm.jobs, id)
+			jm.mutex.Unlock()
+		})
+	}()
+
+	return id, nil
+}
+
+// Get returns the job with id, provided it's owned by ownerId.
+func (jm *JobManager) Get(id, ownerId string) (*Job, error) {
+	jm.mutex.Lock()
+	job, exists := jm.jobs[id]
+	jm.mutex.Unlock()
+
+	if !exists || job.Owner != ownerId {
+		return nil, fmt.Errorf("job not found")
+	}
+
+	return job, nil
+}
+
+// List returns every job owned by ownerId.
+func (jm *JobManager) List(ownerId string) []*Job {
+	jm.mutex.Lock()
+	defer jm.mutex.Unlock()
+
+	jobs := make([]*Job, 0)
+	for _, job := range jm.jobs {
+		if job.Owner == ownerId {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs
+}
+
+// Cancel marks the job with id, owned by ownerId, as canceled. Submit
+// takes no context and fn is not interrupted: it runs to completion, and
+// if Cancel was called before fn returned the job ends as JobCanceled
+// whatever fn returned. Cancelling a job that has already finished does
+// not change its status.
+func (jm *JobManager) Cancel(id, ownerId string) error {
+	job, err := jm.Get(id, ownerId)
+	if err != nil {
+		return err
+	}
+
+	job.cancel()
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package boringproxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobManagerSubmitSurvivesAfterCallerMovesOn(t *testing.T) {
+	jm := NewJobManager()
+
+	jobId, err := jm.Submit("owner", "test", func(progress chan<- Event) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	job, err := jm.Get(jobId, "owner")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	select {
+	case <-job.done:
+	case <-time.After(time.Second):
+		t.Fatal("job never finished")
+	}
+
+	if status := job.Status(); status != JobDone {
+		t.Fatalf("job.Status() = %q, want %q", status, JobDone)
+	}
+}
+
+func TestJobManagerCancel(t *testing.T) {
+	jm := NewJobManager()
+
+	jobId, err := jm.Submit("owner", "test", func(progress chan<- Event) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	if err := jm.Cancel(jobId, "owner"); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+
+	job, err := jm.Get(jobId, "owner")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	select {
+	case <-job.done:
+	case <-time.After(time.Second):
+		t.Fatal("job never finished")
+	}
+
+	if status := job.Status(); status != JobCanceled {
+		t.Fatalf("job.Status() = %q, want %q", status, JobCanceled)
+	}
+}
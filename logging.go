@@ -0,0 +1,219 @@
+package boringproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAccessLogMaxBytes = 10 * 1024 * 1024
+
+// accessLogEntry is one structured JSON line written for every WebUI
+// request: who made it, what they hit, how it went, and how long it took.
+type accessLogEntry struct {
+	Time      string `json:"time"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	Owner     string `json:"owner,omitempty"`
+	RemoteIp  string `json:"remote_ip"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// RequestLogger writes accessLogEntry lines to a configurable sink
+// (stdout, or a rotating Config.LogFile).
+type RequestLogger struct {
+	sink io.Writer
+}
+
+func newRequestLogger(config *Config) (*RequestLogger, error) {
+	if config.LogFile == "" {
+		return &RequestLogger{sink: os.Stdout}, nil
+	}
+
+	rotating, err := newRotatingFileWriter(config.LogFile, defaultAccessLogMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RequestLogger{sink: rotating}, nil
+}
+
+func (l *RequestLogger) log(entry accessLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	l.sink.Write(append(line, '\n'))
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status
+// code a handler actually sent, defaulting to 200 the same way net/http
+// does when a handler never calls WriteHeader itself.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush lets statusRecorder participate in streaming responses (e.g. the
+// SSE job-events endpoint): embedding http.ResponseWriter only promotes
+// Header/Write/WriteHeader, so without this the underlying writer's
+// http.Flusher would be invisible to a type assertion against the
+// wrapper.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// clientIp returns the real client address for r, honoring
+// X-Forwarded-For (set by the reverse proxy in front of the WebUI) over
+// the immediate RemoteAddr.
+func clientIp(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// rotatingFileWriter is an io.Writer over a file on disk that renames
+// the file aside and starts a fresh one once it crosses maxBytes.
+type rotatingFileWriter struct {
+	mutex    sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+}
+
+func newRotatingFileWriter(path string, maxBytes int64) (*rotatingFileWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &rotatingFileWriter{path: path, maxBytes: maxBytes, file: f, size: info.Size()}, nil
+}
+
+func (w *rotatingFileWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+func (w *rotatingFileWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	rotatedPath := fmt.Sprintf("%s.%d", w.path, time.Now().Unix())
+	if err := os.Rename(w.path, rotatedPath); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.file = f
+	w.size = 0
+
+	return nil
+}
+
+// TunnelLogEntry is one structured line of proxied tunnel traffic,
+// recorded by the TunnelManager as requests pass through a tunnel.
+type TunnelLogEntry struct {
+	Time     string `json:"time"`
+	Domain   string `json:"domain"`
+	Upstream string `json:"upstream"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Status   int    `json:"status"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+}
+
+const tunnelLogMaxLines = 200
+
+// TunnelAccessLog keeps the last tunnelLogMaxLines TunnelLogEntry values
+// per domain in memory, so a tunnel's owner can see recent traffic
+// through the WebUI without needing shell access to the server.
+type TunnelAccessLog struct {
+	mutex    sync.Mutex
+	perTun   map[string][]TunnelLogEntry
+	maxLines int
+}
+
+func NewTunnelAccessLog(maxLines int) *TunnelAccessLog {
+	return &TunnelAccessLog{perTun: make(map[string][]TunnelLogEntry), maxLines: maxLines}
+}
+
+// Record appends entry to its domain's log, trimming the oldest lines
+// once the domain's log exceeds maxLines.
+func (t *TunnelAccessLog) Record(entry TunnelLogEntry) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	lines := append(t.perTun[entry.Domain], entry)
+	if len(lines) > t.maxLines {
+		lines = lines[len(lines)-t.maxLines:]
+	}
+
+	t.perTun[entry.Domain] = lines
+}
+
+// Last returns a copy of the most recent log lines recorded for domain.
+func (t *TunnelAccessLog) Last(domain string) []TunnelLogEntry {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	lines := t.perTun[domain]
+	out := make([]TunnelLogEntry, len(lines))
+	copy(out, lines)
+
+	return out
+}
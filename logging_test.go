@@ -0,0 +1,23 @@
+package boringproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusRecorderImplementsFlusher(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var w http.ResponseWriter = &statusRecorder{ResponseWriter: rec}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("statusRecorder does not implement http.Flusher")
+	}
+
+	flusher.Flush()
+
+	if !rec.Flushed {
+		t.Fatal("Flush did not reach the underlying ResponseWriter")
+	}
+}
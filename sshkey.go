@@ -0,0 +1,46 @@
+package boringproxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// supportedSshKeyTypes mirrors the key types the tunnel client/server
+// actually accept; anything else is rejected up front with a friendly
+// error instead of surfacing as an opaque downstream failure.
+var supportedSshKeyTypes = map[string]bool{
+	"ssh-rsa":             true,
+	"ssh-ed25519":         true,
+	"ecdsa-sha2-nistp256": true,
+	"ecdsa-sha2-nistp384": true,
+	"ecdsa-sha2-nistp521": true,
+}
+
+// parseSshKey validates a pasted OpenSSH public key line of the form
+// "<type> <base64-body> [comment]" and returns it back trimmed to just
+// the type and body (the comment, if present, is dropped since it's not
+// used for authentication and shouldn't be trusted as display data).
+func parseSshKey(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("SSH key must not be empty")
+	}
+
+	parts := strings.Fields(raw)
+	if len(parts) < 2 {
+		return "", fmt.Errorf("SSH key must have a type and a body")
+	}
+
+	keyType, body := parts[0], parts[1]
+
+	if !supportedSshKeyTypes[keyType] {
+		return "", fmt.Errorf("unsupported SSH key type %q", keyType)
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(body); err != nil {
+		return "", fmt.Errorf("SSH key body is not valid base64")
+	}
+
+	return keyType + " " + body, nil
+}
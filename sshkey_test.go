@@ -0,0 +1,63 @@
+package boringproxy
+
+import "testing"
+
+func TestParseSshKey(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "valid ed25519 with comment",
+			raw:  "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKo= user@host",
+			want: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKo=",
+		},
+		{
+			name: "valid rsa without comment",
+			raw:  "ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB",
+			want: "ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB",
+		},
+		{
+			name:    "empty",
+			raw:     "   ",
+			wantErr: true,
+		},
+		{
+			name:    "missing body",
+			raw:     "ssh-ed25519",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported type",
+			raw:     "ssh-dss AAAAB3NzaC1kc3MAAACB",
+			wantErr: true,
+		},
+		{
+			name:    "invalid base64 body",
+			raw:     "ssh-ed25519 not-valid-base64!!",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseSshKey(c.raw)
+
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseSshKey(%q) = %q, nil; want error", c.raw, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseSshKey(%q) returned error: %v", c.raw, err)
+			}
+			if got != c.want {
+				t.Fatalf("parseSshKey(%q) = %q, want %q", c.raw, got, c.want)
+			}
+		})
+	}
+}
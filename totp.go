@@ -0,0 +1,119 @@
+package boringproxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpPeriod        = 30 * time.Second
+	totpDigits        = 6
+	totpSkewPeriods   = 1
+	totpMaxAttempts   = 5
+	totpAttemptWindow = 15 * time.Minute
+)
+
+var totpBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTotpSecret returns a fresh random 20-byte TOTP secret, base32
+// encoded the way authenticator apps expect it.
+func generateTotpSecret() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return totpBase32.EncodeToString(raw), nil
+}
+
+// totpProvisioningUri builds the otpauth:// URI an authenticator app's
+// QR scanner expects during enrollment.
+func totpProvisioningUri(issuer, account, secret string) string {
+	label := fmt.Sprintf("%s:%s", issuer, account)
+
+	return fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s&digits=%d&period=%d",
+		url.PathEscape(label), secret, url.QueryEscape(issuer), totpDigits, int(totpPeriod.Seconds()))
+}
+
+// verifyTotpCode checks code against secret, allowing +/- totpSkewPeriods
+// of clock skew between client and server, per RFC 6238.
+func verifyTotpCode(secret, code string) bool {
+	key, err := totpBase32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return false
+	}
+
+	counter := time.Now().Unix() / int64(totpPeriod.Seconds())
+
+	for delta := -totpSkewPeriods; delta <= totpSkewPeriods; delta++ {
+		if hotp(key, counter+int64(delta)) == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hotp(key []byte, counter int64) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(buf)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	code := truncated % uint32(math.Pow10(totpDigits))
+
+	return fmt.Sprintf("%0*d", totpDigits, code)
+}
+
+// pendingTotpEnrollments holds secrets generated by /enroll-totp that
+// haven't been confirmed with a valid code yet, so a botched scan can't
+// permanently lock a user out of their own account.
+type pendingTotpEnrollments struct {
+	mutex   sync.Mutex
+	secrets map[string]string
+}
+
+func newPendingTotpEnrollments() *pendingTotpEnrollments {
+	return &pendingTotpEnrollments{secrets: make(map[string]string)}
+}
+
+func (p *pendingTotpEnrollments) Start(owner string) (string, error) {
+	secret, err := generateTotpSecret()
+	if err != nil {
+		return "", err
+	}
+
+	p.mutex.Lock()
+	p.secrets[owner] = secret
+	p.mutex.Unlock()
+
+	return secret, nil
+}
+
+func (p *pendingTotpEnrollments) Get(owner string) (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	secret, ok := p.secrets[owner]
+
+	return secret, ok
+}
+
+func (p *pendingTotpEnrollments) Clear(owner string) {
+	p.mutex.Lock()
+	delete(p.secrets, owner)
+	p.mutex.Unlock()
+}
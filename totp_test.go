@@ -0,0 +1,90 @@
+package boringproxy
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the ASCII "12345678901234567890" test vector from
+// RFC 4226 / RFC 6238's worked examples, base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHotpRfc6238Vectors(t *testing.T) {
+	key, err := totpBase32.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+
+	// Counters 0 and 1 from the RFC 4226 Appendix D HOTP test vectors
+	// for this key, which RFC 6238's TOTP is built directly on top of.
+	cases := map[int64]string{
+		0: "755224",
+		1: "287082",
+	}
+
+	for counter, want := range cases {
+		got := hotp(key, counter)
+		if got != want {
+			t.Errorf("hotp(key, %d) = %q, want %q", counter, got, want)
+		}
+	}
+}
+
+func TestVerifyTotpCodeAcceptsCurrentAndSkewWindow(t *testing.T) {
+	secret, err := generateTotpSecret()
+	if err != nil {
+		t.Fatalf("generateTotpSecret: %v", err)
+	}
+
+	key, err := totpBase32.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+
+	counter := time.Now().Unix() / int64(totpPeriod.Seconds())
+
+	current := hotp(key, counter)
+	if !verifyTotpCode(secret, current) {
+		t.Error("verifyTotpCode rejected the current period's code")
+	}
+
+	prev := hotp(key, counter-1)
+	if !verifyTotpCode(secret, prev) {
+		t.Error("verifyTotpCode rejected the previous period's code (clock skew)")
+	}
+
+	next := hotp(key, counter+1)
+	if !verifyTotpCode(secret, next) {
+		t.Error("verifyTotpCode rejected the next period's code (clock skew)")
+	}
+
+	tooOld := hotp(key, counter-2)
+	if verifyTotpCode(secret, tooOld) {
+		t.Error("verifyTotpCode accepted a code outside the skew window")
+	}
+}
+
+func TestVerifyTotpCodeRejectsBadSecret(t *testing.T) {
+	if verifyTotpCode("not valid base32!!!", "123456") {
+		t.Error("verifyTotpCode accepted an undecodable secret")
+	}
+}
+
+func TestHotpTruncationStaysWithinDigits(t *testing.T) {
+	key := make([]byte, 20)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	for counter := int64(0); counter < 64; counter++ {
+		code := hotp(key, counter)
+		if len(code) != totpDigits {
+			t.Fatalf("hotp(key, %d) = %q, want %d digits", counter, code, totpDigits)
+		}
+
+		if _, err := strconv.Atoi(code); err != nil {
+			t.Fatalf("code %q is not numeric: %v", code, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package boringproxy
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// countingReadCloser wraps an io.ReadCloser to tally the bytes read
+// through it, so the request body size can be measured without buffering
+// it.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingResponseWriter wraps an http.ResponseWriter to capture the
+// status code and response body size of a proxied request, mirroring
+// what statusRecorder does for the WebUI.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+
+	return n, err
+}
+
+// LoggingMiddleware wraps next -- the handler TunnelManager dispatches a
+// domain's proxied traffic to -- so every request through the tunnel is
+// recorded to t: method, path, status, and bytes in/out. TunnelManager.
+// ServeHTTP installs this around each tunnel's upstream handler on every
+// request, once an access log has been set via SetAccessLog.
+func (t *TunnelAccessLog) LoggingMiddleware(domain, upstream string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &countingReadCloser{ReadCloser: r.Body}
+		r.Body = body
+
+		counting := &countingResponseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(counting, r)
+
+		t.Record(TunnelLogEntry{
+			Time:     time.Now().Format(time.RFC3339),
+			Domain:   domain,
+			Upstream: upstream,
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Status:   counting.status,
+			BytesIn:  body.n,
+			BytesOut: counting.n,
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package boringproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTunnelAccessLogLoggingMiddlewareRecordsEntry(t *testing.T) {
+	logs := NewTunnelAccessLog(tunnelLogMaxLines)
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	})
+
+	handler := logs.LoggingMiddleware("example.com", "127.0.0.1:8080", upstream)
+
+	req := httptest.NewRequest("POST", "/hook", strings.NewReader("payload"))
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTeapot {
+		t.Fatalf("response code = %d, want %d", rec.Code, http.StatusTeapot)
+	}
+
+	entries := logs.Last("example.com")
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(entries))
+	}
+
+	entry := entries[0]
+	if entry.Domain != "example.com" {
+		t.Errorf("Domain = %q, want %q", entry.Domain, "example.com")
+	}
+	if entry.Upstream != "127.0.0.1:8080" {
+		t.Errorf("Upstream = %q, want %q", entry.Upstream, "127.0.0.1:8080")
+	}
+	if entry.Method != "POST" {
+		t.Errorf("Method = %q, want %q", entry.Method, "POST")
+	}
+	if entry.Path != "/hook" {
+		t.Errorf("Path = %q, want %q", entry.Path, "/hook")
+	}
+	if entry.Status != http.StatusTeapot {
+		t.Errorf("Status = %d, want %d", entry.Status, http.StatusTeapot)
+	}
+	if entry.BytesIn != int64(len("payload")) {
+		t.Errorf("BytesIn = %d, want %d", entry.BytesIn, len("payload"))
+	}
+	if entry.BytesOut != int64(len("hello")) {
+		t.Errorf("BytesOut = %d, want %d", entry.BytesOut, len("hello"))
+	}
+}
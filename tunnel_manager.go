@@ -0,0 +1,79 @@
+package boringproxy
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+)
+
+// tunnelRoute is a single domain's proxy target: where to forward
+// traffic, and the reverse proxy handler built for it.
+type tunnelRoute struct {
+	upstream string
+	proxy    http.Handler
+}
+
+// TunnelManager dispatches incoming HTTP requests for each active
+// tunnel's domain to the client's registered upstream address.
+type TunnelManager struct {
+	mutex  sync.RWMutex
+	routes map[string]tunnelRoute
+	logs   *TunnelAccessLog
+}
+
+func NewTunnelManager() *TunnelManager {
+	return &TunnelManager{routes: make(map[string]tunnelRoute)}
+}
+
+// SetAccessLog installs logs so every request proxied through a tunnel
+// from this point on is recorded to it. WebUiHandler calls this once at
+// startup with the same TunnelAccessLog its /tunnels/{domain}/log page
+// reads from.
+func (tm *TunnelManager) SetAccessLog(logs *TunnelAccessLog) {
+	tm.mutex.Lock()
+	tm.logs = logs
+	tm.mutex.Unlock()
+}
+
+// AddTunnel registers domain to proxy to upstream.
+func (tm *TunnelManager) AddTunnel(domain, upstream string) error {
+	target, err := url.Parse("http://" + upstream)
+	if err != nil {
+		return err
+	}
+
+	tm.mutex.Lock()
+	tm.routes[domain] = tunnelRoute{upstream: upstream, proxy: httputil.NewSingleHostReverseProxy(target)}
+	tm.mutex.Unlock()
+
+	return nil
+}
+
+// RemoveTunnel stops proxying domain.
+func (tm *TunnelManager) RemoveTunnel(domain string) {
+	tm.mutex.Lock()
+	delete(tm.routes, domain)
+	tm.mutex.Unlock()
+}
+
+// ServeHTTP proxies r to the upstream registered for r.Host, recording
+// the request to the installed TunnelAccessLog if one is set.
+func (tm *TunnelManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tm.mutex.RLock()
+	route, exists := tm.routes[r.Host]
+	logs := tm.logs
+	tm.mutex.RUnlock()
+
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	handler := route.proxy
+	if logs != nil {
+		handler = logs.LoggingMiddleware(r.Host, route.upstream, handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
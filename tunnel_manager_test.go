@@ -0,0 +1,61 @@
+package boringproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestTunnelManagerServeHTTPRecordsAccessLog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatalf("parse upstream URL: %v", err)
+	}
+
+	tm := NewTunnelManager()
+	logs := NewTunnelAccessLog(tunnelLogMaxLines)
+	tm.SetAccessLog(logs)
+
+	if err := tm.AddTunnel("tunnel.example.com", upstreamURL.Host); err != nil {
+		t.Fatalf("AddTunnel: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "http://tunnel.example.com/hi", nil)
+	req.Host = "tunnel.example.com"
+	rec := httptest.NewRecorder()
+
+	tm.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("response code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	entries := logs.Last("tunnel.example.com")
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(entries))
+	}
+	if entries[0].Status != http.StatusOK {
+		t.Errorf("Status = %d, want %d", entries[0].Status, http.StatusOK)
+	}
+}
+
+func TestTunnelManagerServeHTTPUnknownDomain(t *testing.T) {
+	tm := NewTunnelManager()
+
+	req := httptest.NewRequest("GET", "http://unknown.example.com/", nil)
+	req.Host = "unknown.example.com"
+	rec := httptest.NewRecorder()
+
+	tm.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("response code = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
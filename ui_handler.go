@@ -8,42 +8,48 @@ import (
 	"html/template"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
-	"sync"
 	"time"
 )
 
 type WebUiHandler struct {
-	config          *Config
-	db              *Database
-	api             *Api
-	auth            *Auth
-	tunMan          *TunnelManager
-	box             *rice.Box
-	headHtml        template.HTML
-	pendingRequests map[string]chan ReqResult
-	mutex           *sync.Mutex
+	config      *Config
+	db          *Database
+	api         *Api
+	auth        *Auth
+	tunMan      *TunnelManager
+	box         *rice.Box
+	headTmpl    *template.Template
+	styles      template.CSS
+	csrf        *csrfManager
+	jobs        *JobManager
+	logger      *RequestLogger
+	tunnelLogs  *TunnelAccessLog
+	pendingTotp *pendingTotpEnrollments
 }
 
-type ReqResult struct {
-	err         error
-	redirectUrl string
+type IndexData struct {
+	Head      template.HTML
+	Tunnels   map[string]Tunnel
+	Tokens    map[string]TokenData
+	SshKeys   map[string]SshKey
+	Users     map[string]User
+	UserId    string
+	IsAdmin   bool
+	QrCodes   map[string]template.URL
+	CsrfToken string
 }
 
-type IndexData struct {
+type TunnelsData struct {
 	Head    template.HTML
 	Tunnels map[string]Tunnel
-	Tokens  map[string]TokenData
-	SshKeys map[string]SshKey
-	Users   map[string]User
-	UserId  string
-	IsAdmin bool
-	QrCodes map[string]template.URL
 }
 
-type TunnelsData struct {
+type TunnelLogData struct {
 	Head    template.HTML
-	Tunnels map[string]Tunnel
+	Domain  string
+	Entries []TunnelLogEntry
 }
 
 type ConfirmData struct {
@@ -51,11 +57,14 @@ type ConfirmData struct {
 	Message    string
 	ConfirmUrl string
 	CancelUrl  string
+	CsrfToken  string
 }
 
 type LoadingData struct {
 	Head      template.HTML
 	TargetUrl string
+	EventsUrl string
+	CsrfToken string
 }
 
 type AlertData struct {
@@ -65,11 +74,21 @@ type AlertData struct {
 }
 
 type LoginData struct {
-	Head template.HTML
+	Head         template.HTML
+	TotpRequired bool
+	AccessToken  string
+}
+
+type EnrollTotpData struct {
+	Head      template.HTML
+	QrCode    template.URL
+	Secret    string
+	CsrfToken string
 }
 
 type HeadData struct {
-	Styles template.CSS
+	Styles    template.CSS
+	CsrfToken string
 }
 
 type MenuData struct {
@@ -87,23 +106,73 @@ type TokensData struct {
 	Users  map[string]User
 }
 
+// destructiveRoutes are WebUI actions that mutate or delete state. They
+// require a POST request (so browsers can't trigger them via a bare link
+// or image prefetch) and a valid CSRF token.
+var destructiveRoutes = map[string]bool{
+	"/delete-tunnel": true,
+	"/delete-token":  true,
+	"/delete-user":   true,
+	"/logout":        true,
+}
+
 func NewWebUiHandler(config *Config, db *Database, api *Api, auth *Auth, tunMan *TunnelManager) *WebUiHandler {
+
+	csrf, err := newCsrfManager()
+	if err != nil {
+		panic("failed to initialize CSRF manager: " + err.Error())
+	}
+
+	logger, err := newRequestLogger(config)
+	if err != nil {
+		panic("failed to initialize request logger: " + err.Error())
+	}
+
+	tunnelLogs := NewTunnelAccessLog(tunnelLogMaxLines)
+	tunMan.SetAccessLog(tunnelLogs)
+
 	return &WebUiHandler{
-		config:          config,
-		db:              db,
-		api:             api,
-		auth:            auth,
-		tunMan:          tunMan,
-		pendingRequests: make(map[string]chan ReqResult),
-		mutex:           &sync.Mutex{},
+		config:      config,
+		db:          db,
+		api:         api,
+		auth:        auth,
+		tunMan:      tunMan,
+		csrf:        csrf,
+		jobs:        NewJobManager(),
+		logger:      logger,
+		tunnelLogs:  tunnelLogs,
+		pendingTotp: newPendingTotpEnrollments(),
 	}
 }
 
 func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request) {
 
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w}
+	w = recorder
+
+	var owner string
+	defer func() {
+		status := recorder.status
+		if status == 0 {
+			// Nothing was written; net/http sends 200 in that case.
+			status = http.StatusOK
+		}
+
+		h.logger.log(accessLogEntry{
+			Time:      start.Format(time.RFC3339),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    status,
+			Owner:     owner,
+			RemoteIp:  clientIp(r),
+			LatencyMs: time.Since(start).Milliseconds(),
+		})
+	}()
+
 	homePath := "/#/tunnel"
 
-	// Note: h.box and h.headHtml need to be ready before pretty much
+	// Note: h.box and h.headTmpl need to be ready before pretty much
 	// everything else, including sendLoginPage
 
 	box, err := rice.FindBox("webui")
@@ -120,6 +189,8 @@ func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request
 		io.WriteString(w, "Error reading styles.css")
 		return
 	}
+	h.styles = template.CSS(stylesText)
+
 	headTmplStr, err := box.String("head.tmpl")
 	if err != nil {
 		w.WriteHeader(500)
@@ -132,9 +203,7 @@ func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request
 		io.WriteString(w, "Error compiling head.tmpl")
 		return
 	}
-	var headBuilder strings.Builder
-	headTmpl.Execute(&headBuilder, HeadData{Styles: template.CSS(stylesText)})
-	h.headHtml = template.HTML(headBuilder.String())
+	h.headTmpl = headTmpl
 
 	token, err := extractToken("access_token", r)
 	if err != nil {
@@ -147,6 +216,7 @@ func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request
 		h.sendLoginPage(w, r, 403)
 		return
 	}
+	owner = tokenData.Owner
 
 	user, _ := h.db.GetUser(tokenData.Owner)
 
@@ -158,6 +228,34 @@ func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request
 		tunnels[domain] = tun
 	}
 
+	if _, err := h.ensureCsrfCookie(w, r); err != nil {
+		w.WriteHeader(500)
+		h.alertDialog(w, r, "Error establishing CSRF cookie", homePath)
+		return
+	}
+
+	if r.Method != "GET" || destructiveRoutes[r.URL.Path] {
+		r.ParseForm()
+
+		if !h.csrf.Verify(csrfCookieValue(r), r.FormValue("csrf_token")) {
+			w.WriteHeader(403)
+			h.alertDialog(w, r, "Request could not be verified", homePath)
+			return
+		}
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/jobs/") && strings.HasSuffix(r.URL.Path, "/events") {
+		jobId := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/events")
+		h.handleJobEvents(w, r, tokenData, jobId)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/tunnels/") && strings.HasSuffix(r.URL.Path, "/log") {
+		domain := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tunnels/"), "/log")
+		h.handleTunnelLog(w, r, tokenData, domain)
+		return
+	}
+
 	switch r.URL.Path {
 	case "/login":
 		h.handleLogin(w, r)
@@ -235,14 +333,15 @@ func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request
 		}
 
 		indexData := IndexData{
-			Head:    h.headHtml,
-			Tunnels: tunnels,
-			Tokens:  tokens,
-			SshKeys: h.api.GetSshKeys(tokenData),
-			Users:   users,
-			UserId:  tokenData.Owner,
-			IsAdmin: user.IsAdmin,
-			QrCodes: qrCodes,
+			Head:      h.renderHead(r),
+			Tunnels:   tunnels,
+			Tokens:    tokens,
+			SshKeys:   h.api.GetSshKeys(tokenData),
+			Users:     users,
+			UserId:    tokenData.Owner,
+			IsAdmin:   user.IsAdmin,
+			QrCodes:   qrCodes,
+			CsrfToken: h.currentCsrfToken(r),
 		}
 
 		err = tmpl.Execute(w, indexData)
@@ -272,17 +371,25 @@ func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request
 			return
 		}
 
+		csrfToken := h.currentCsrfToken(r)
 		data := &ConfirmData{
-			Head:       h.headHtml,
+			Head:       h.renderHead(r),
 			Message:    fmt.Sprintf("Are you sure you want to delete %s?", domain),
-			ConfirmUrl: fmt.Sprintf("/delete-tunnel?domain=%s", domain),
+			ConfirmUrl: fmt.Sprintf("/delete-tunnel?domain=%s&csrf_token=%s", domain, csrfToken),
 			CancelUrl:  "/#/tunnels",
+			CsrfToken:  csrfToken,
 		}
 
 		tmpl.Execute(w, data)
 
 	case "/delete-tunnel":
 
+		if r.Method != "POST" {
+			w.WriteHeader(405)
+			h.alertDialog(w, r, "Invalid method for /delete-tunnel", "/#/tunnels")
+			return
+		}
+
 		r.ParseForm()
 
 		err := h.api.DeleteTunnel(tokenData, r.Form)
@@ -312,20 +419,28 @@ func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request
 		h.confirmDeleteToken(w, r)
 	case "/delete-token":
 		h.deleteToken(w, r, tokenData)
-	//case "/ssh-keys":
-	//	h.handleSshKeys(w, r, user, tokenData)
-	//case "/delete-ssh-key":
+	case "/ssh-keys":
+		h.handleSshKeys(w, r, user, tokenData)
+	case "/confirm-delete-ssh-key":
+		h.confirmDeleteSshKey(w, r)
+	case "/delete-ssh-key":
+
+		if r.Method != "POST" {
+			w.WriteHeader(405)
+			h.alertDialog(w, r, "Invalid method for /delete-ssh-key", "/#/ssh-keys")
+			return
+		}
 
-	//	r.ParseForm()
+		r.ParseForm()
 
-	//	err := h.api.DeleteSshKey(tokenData, r.Form)
-	//	if err != nil {
-	//		w.WriteHeader(400)
-	//		h.alertDialog(w, r, err.Error(), "/#/ssh-keys")
-	//		return
-	//	}
+		err := h.api.DeleteSshKey(tokenData, r.Form)
+		if err != nil {
+			w.WriteHeader(400)
+			h.alertDialog(w, r, err.Error(), "/#/ssh-keys")
+			return
+		}
 
-	//	http.Redirect(w, r, "/#/ssh-keys", 303)
+		http.Redirect(w, r, "/#/ssh-keys", 303)
 
 	case "/confirm-logout":
 		tmpl, err := h.loadTemplate("confirm.tmpl")
@@ -335,16 +450,24 @@ func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request
 			return
 		}
 
+		csrfToken := h.currentCsrfToken(r)
 		data := &ConfirmData{
-			Head:       h.headHtml,
+			Head:       h.renderHead(r),
 			Message:    "Are you sure you want to log out?",
-			ConfirmUrl: "/logout",
+			ConfirmUrl: fmt.Sprintf("/logout?csrf_token=%s", csrfToken),
 			CancelUrl:  "/#/tunnels",
+			CsrfToken:  csrfToken,
 		}
 
 		tmpl.Execute(w, data)
 
 	case "/logout":
+		if r.Method != "POST" {
+			w.WriteHeader(405)
+			h.alertDialog(w, r, "Invalid method for /logout", "/#/tunnels")
+			return
+		}
+
 		cookie := &http.Cookie{
 			Name:     "access_token",
 			Value:    "",
@@ -354,7 +477,11 @@ func (h *WebUiHandler) handleWebUiRequest(w http.ResponseWriter, r *http.Request
 		http.SetCookie(w, cookie)
 		http.Redirect(w, r, "/#/tunnels", 303)
 	case "/loading":
-		h.handleLoading(w, r)
+		h.handleLoading(w, r, tokenData)
+	case "/enroll-totp":
+		h.handleEnrollTotp(w, r, tokenData)
+	case "/reset-totp":
+		h.resetTotp(w, r, user, tokenData)
 	default:
 		w.WriteHeader(404)
 		h.alertDialog(w, r, "Unknown page "+r.URL.Path, "/#/tunnels")
@@ -382,47 +509,48 @@ func (h *WebUiHandler) handleTokens(w http.ResponseWriter, r *http.Request, user
 	http.Redirect(w, r, "/#/tokens", 303)
 }
 
-func (h *WebUiHandler) handleSshKeys(w http.ResponseWriter, r *http.Request, user User, tokenData TokenData) {
+// SshKeysData is the data rendered by the dedicated /ssh-keys page,
+// scoped to the keys the requesting user is allowed to see.
+type SshKeysData struct {
+	Head      template.HTML
+	SshKeys   map[string]SshKey
+	CsrfToken string
+}
 
-	if r.Method != "POST" {
-		w.WriteHeader(405)
-		h.alertDialog(w, r, "Invalid method for /ssh-keys", "/#/ssh-keys")
-		return
-	}
+func (h *WebUiHandler) handleSshKeys(w http.ResponseWriter, r *http.Request, user User, tokenData TokenData) {
 
-	r.ParseForm()
+	switch r.Method {
+	case "GET":
+		tmpl, err := h.loadTemplate("ssh-keys.tmpl")
+		if err != nil {
+			w.WriteHeader(500)
+			h.alertDialog(w, r, err.Error(), "/#/ssh-keys")
+			return
+		}
 
-	id := r.Form.Get("id")
-	if id == "" {
-		w.WriteHeader(400)
-		h.alertDialog(w, r, "Invalid id parameter", "/#/ssh-keys")
-		return
-	}
+		data := &SshKeysData{
+			Head:      h.renderHead(r),
+			SshKeys:   h.api.GetSshKeys(tokenData),
+			CsrfToken: h.currentCsrfToken(r),
+		}
 
-	keyParam := r.Form.Get("key")
-	if keyParam == "" {
-		w.WriteHeader(400)
-		h.alertDialog(w, r, "Invalid key parameter", "/#/ssh-keys")
-		return
-	}
+		tmpl.Execute(w, data)
 
-	keyParam = strings.TrimSpace(keyParam)
-	parts := strings.Split(keyParam, " ")
+	case "POST":
+		r.ParseForm()
 
-	if len(parts) > 2 {
-		keyParam = strings.Join(parts[:2], " ")
-	}
+		if err := h.api.CreateSshKey(tokenData, r.Form); err != nil {
+			w.WriteHeader(400)
+			h.alertDialog(w, r, err.Error(), "/#/ssh-keys")
+			return
+		}
 
-	key := SshKey{Owner: tokenData.Owner, Key: keyParam}
+		http.Redirect(w, r, "/#/ssh-keys", 303)
 
-	err := h.db.AddSshKey(id, key)
-	if err != nil {
-		w.WriteHeader(400)
-		h.alertDialog(w, r, err.Error(), "/#/ssh-keys")
-		return
+	default:
+		w.WriteHeader(405)
+		h.alertDialog(w, r, "Invalid method for /ssh-keys", "/#/ssh-keys")
 	}
-
-	http.Redirect(w, r, "/#/ssh-keys", 303)
 }
 
 func (h *WebUiHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -430,6 +558,7 @@ func (h *WebUiHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		w.WriteHeader(405)
 		w.Write([]byte("Invalid method for login"))
+		return
 	}
 
 	r.ParseForm()
@@ -444,20 +573,64 @@ func (h *WebUiHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	token := tokenList[0]
 
-	if h.auth.Authorized(token) {
-		cookie := &http.Cookie{
-			Name:     "access_token",
-			Value:    token,
-			Secure:   true,
-			HttpOnly: true,
-			MaxAge:   86400 * 365,
-		}
-		http.SetCookie(w, cookie)
-		http.Redirect(w, r, "/#/tunnels", 303)
-	} else {
+	if !h.auth.Authorized(token) {
 		h.sendLoginPage(w, r, 403)
 		return
 	}
+
+	tokenData, exists := h.db.GetTokenData(token)
+	if exists {
+		if secret, enrolled := h.db.GetTotpSecret(tokenData.Owner); enrolled {
+			if h.auth.TotpLocked(token) {
+				h.alertDialog(w, r, "Too many incorrect codes, try again later", "/")
+				return
+			}
+
+			code := r.Form.Get("totp_code")
+			if code == "" {
+				h.sendTotpLoginPage(w, r)
+				return
+			}
+
+			if !verifyTotpCode(secret, code) {
+				h.auth.RecordTotpFailure(token)
+				h.sendTotpLoginPage(w, r)
+				return
+			}
+
+			h.auth.ResetTotpFailures(token)
+		}
+	}
+
+	cookie := &http.Cookie{
+		Name:     "access_token",
+		Value:    token,
+		Secure:   true,
+		HttpOnly: true,
+		MaxAge:   86400 * 365,
+	}
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, "/#/tunnels", 303)
+}
+
+// sendTotpLoginPage re-renders the login page prompting for the 6-digit
+// TOTP code, keeping the access_token query param so the form can
+// resubmit it alongside the code.
+func (h *WebUiHandler) sendTotpLoginPage(w http.ResponseWriter, r *http.Request) {
+	loginData := LoginData{
+		Head:         h.renderHead(r),
+		TotpRequired: true,
+		AccessToken:  r.Form.Get("access_token"),
+	}
+
+	w.WriteHeader(401)
+	tmpl, err := h.loadTemplate("login.tmpl")
+	if err != nil {
+		io.WriteString(w, "Error reading login.tmpl")
+		return
+	}
+
+	tmpl.Execute(w, loginData)
 }
 
 func (h *WebUiHandler) handleTunnels(w http.ResponseWriter, r *http.Request, tokenData TokenData) {
@@ -474,59 +647,42 @@ func (h *WebUiHandler) handleTunnels(w http.ResponseWriter, r *http.Request, tok
 
 func (h *WebUiHandler) handleCreateTunnel(w http.ResponseWriter, r *http.Request, tokenData TokenData) {
 
-	pendingId, err := genRandomCode(16)
-	if err != nil {
-		w.WriteHeader(400)
-		h.alertDialog(w, r, err.Error(), "/#/tunnels")
-	}
-
-	doneSignal := make(chan ReqResult)
-	h.mutex.Lock()
-	h.pendingRequests[pendingId] = doneSignal
-	h.mutex.Unlock()
-
-	go func() {
-
-		r.ParseForm()
-
-		_, err := h.api.CreateTunnel(tokenData, r.Form)
-
-		doneSignal <- ReqResult{err, "/#/tunnels"}
-	}()
-
-	timeout := make(chan bool, 1)
-	go func() {
-		time.Sleep(100 * time.Millisecond)
-		timeout <- true
-	}()
+	r.ParseForm()
+	form := r.Form
 
-	select {
-	case <-timeout:
-		url := fmt.Sprintf("/loading?id=%s", pendingId)
+	jobId, err := h.jobs.Submit(tokenData.Owner, "create-tunnel", func(progress chan<- Event) error {
+		progress <- Event{Name: "progress", Data: "allocating port"}
 
-		tmpl, err := h.loadTemplate("loading.tmpl")
+		_, err := h.api.CreateTunnel(tokenData, form)
 		if err != nil {
-			w.WriteHeader(500)
-			h.alertDialog(w, r, err.Error(), "/#/tunnels")
-			return
+			return err
 		}
 
-		data := &LoadingData{
-			Head:      h.headHtml,
-			TargetUrl: url,
-		}
+		progress <- Event{Name: "progress", Data: "waiting for client"}
 
-		tmpl.Execute(w, data)
+		return nil
+	})
+	if err != nil {
+		w.WriteHeader(400)
+		h.alertDialog(w, r, err.Error(), "/#/tunnels")
+		return
+	}
 
-	case result := <-doneSignal:
-		if result.err != nil {
-			w.WriteHeader(400)
-			h.alertDialog(w, r, result.err.Error(), result.redirectUrl)
-			return
-		}
+	tmpl, err := h.loadTemplate("loading.tmpl")
+	if err != nil {
+		w.WriteHeader(500)
+		h.alertDialog(w, r, err.Error(), "/#/tunnels")
+		return
+	}
 
-		http.Redirect(w, r, result.redirectUrl, 303)
+	data := &LoadingData{
+		Head:      h.renderHead(r),
+		TargetUrl: "/#/tunnels",
+		EventsUrl: fmt.Sprintf("/jobs/%s/events", jobId),
+		CsrfToken: h.currentCsrfToken(r),
 	}
+
+	tmpl.Execute(w, data)
 }
 
 func (h *WebUiHandler) sendLoginPage(w http.ResponseWriter, r *http.Request, code int) {
@@ -546,7 +702,7 @@ func (h *WebUiHandler) sendLoginPage(w http.ResponseWriter, r *http.Request, cod
 	}
 
 	loginData := LoginData{
-		Head: h.headHtml,
+		Head: h.renderHead(r),
 	}
 
 	w.WriteHeader(code)
@@ -591,11 +747,13 @@ func (h *WebUiHandler) confirmDeleteUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	csrfToken := h.currentCsrfToken(r)
 	data := &ConfirmData{
-		Head:       h.headHtml,
+		Head:       h.renderHead(r),
 		Message:    fmt.Sprintf("Are you sure you want to delete user %s?", username),
-		ConfirmUrl: fmt.Sprintf("/delete-user?username=%s", username),
+		ConfirmUrl: fmt.Sprintf("/delete-user?username=%s&csrf_token=%s", username, csrfToken),
 		CancelUrl:  "/#/users",
+		CsrfToken:  csrfToken,
 	}
 
 	tmpl.Execute(w, data)
@@ -603,6 +761,12 @@ func (h *WebUiHandler) confirmDeleteUser(w http.ResponseWriter, r *http.Request)
 
 func (h *WebUiHandler) deleteUser(w http.ResponseWriter, r *http.Request, tokenData TokenData) {
 
+	if r.Method != "POST" {
+		w.WriteHeader(405)
+		h.alertDialog(w, r, "Invalid method for /delete-user", "/#/users")
+		return
+	}
+
 	r.ParseForm()
 
 	err := h.api.DeleteUser(tokenData, r.Form)
@@ -633,11 +797,13 @@ func (h *WebUiHandler) confirmDeleteToken(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	csrfToken := h.currentCsrfToken(r)
 	data := &ConfirmData{
-		Head:       h.headHtml,
+		Head:       h.renderHead(r),
 		Message:    fmt.Sprintf("Are you sure you want to delete token %s?", token),
-		ConfirmUrl: fmt.Sprintf("/delete-token?token=%s", token),
+		ConfirmUrl: fmt.Sprintf("/delete-token?token=%s&csrf_token=%s", token, csrfToken),
 		CancelUrl:  "/#/tokens",
+		CsrfToken:  csrfToken,
 	}
 
 	tmpl.Execute(w, data)
@@ -645,6 +811,12 @@ func (h *WebUiHandler) confirmDeleteToken(w http.ResponseWriter, r *http.Request
 
 func (h *WebUiHandler) deleteToken(w http.ResponseWriter, r *http.Request, tokenData TokenData) {
 
+	if r.Method != "POST" {
+		w.WriteHeader(405)
+		h.alertDialog(w, r, "Invalid method for /delete-token", "/#/tokens")
+		return
+	}
+
 	r.ParseForm()
 	err := h.api.DeleteToken(tokenData, r.Form)
 	if err != nil {
@@ -656,6 +828,175 @@ func (h *WebUiHandler) deleteToken(w http.ResponseWriter, r *http.Request, token
 	http.Redirect(w, r, "/#/tokens", 303)
 }
 
+// handleEnrollTotp walks a user through enabling TOTP: a GET generates a
+// fresh secret and renders it as an otpauth:// QR code, and a POST
+// confirms the user actually captured it before it's persisted, so a
+// botched scan can't lock them out of their own account.
+func (h *WebUiHandler) handleEnrollTotp(w http.ResponseWriter, r *http.Request, tokenData TokenData) {
+
+	switch r.Method {
+	case "GET":
+		secret, err := h.pendingTotp.Start(tokenData.Owner)
+		if err != nil {
+			w.WriteHeader(500)
+			h.alertDialog(w, r, err.Error(), "/#/users")
+			return
+		}
+
+		uri := totpProvisioningUri(h.config.WebUiDomain, tokenData.Owner, secret)
+
+		png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+		if err != nil {
+			w.WriteHeader(500)
+			h.alertDialog(w, r, err.Error(), "/#/users")
+			return
+		}
+
+		tmpl, err := h.loadTemplate("enroll-totp.tmpl")
+		if err != nil {
+			w.WriteHeader(500)
+			h.alertDialog(w, r, err.Error(), "/#/users")
+			return
+		}
+
+		data := &EnrollTotpData{
+			Head:      h.renderHead(r),
+			QrCode:    template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+			Secret:    secret,
+			CsrfToken: h.currentCsrfToken(r),
+		}
+
+		tmpl.Execute(w, data)
+
+	case "POST":
+		r.ParseForm()
+
+		secret, ok := h.pendingTotp.Get(tokenData.Owner)
+		if !ok {
+			w.WriteHeader(400)
+			h.alertDialog(w, r, "No TOTP enrollment in progress", "/#/users")
+			return
+		}
+
+		if !verifyTotpCode(secret, r.Form.Get("code")) {
+			w.WriteHeader(400)
+			h.alertDialog(w, r, "Incorrect code, please scan the QR code again", "/enroll-totp")
+			return
+		}
+
+		if err := h.db.SetTotpSecret(tokenData.Owner, secret); err != nil {
+			w.WriteHeader(500)
+			h.alertDialog(w, r, err.Error(), "/#/users")
+			return
+		}
+
+		h.pendingTotp.Clear(tokenData.Owner)
+
+		http.Redirect(w, r, "/#/users", 303)
+
+	default:
+		w.WriteHeader(405)
+		h.alertDialog(w, r, "Invalid method for /enroll-totp", "/#/users")
+	}
+}
+
+// resetTotp lets an admin clear another user's TOTP secret, e.g. when
+// they've lost their authenticator device.
+func (h *WebUiHandler) resetTotp(w http.ResponseWriter, r *http.Request, user User, tokenData TokenData) {
+
+	if r.Method != "POST" {
+		w.WriteHeader(405)
+		h.alertDialog(w, r, "Invalid method for /reset-totp", "/#/users")
+		return
+	}
+
+	if !user.IsAdmin {
+		w.WriteHeader(403)
+		h.alertDialog(w, r, "Only admins can reset TOTP enrollment", "/#/users")
+		return
+	}
+
+	r.ParseForm()
+
+	username := r.Form.Get("username")
+	if username == "" {
+		w.WriteHeader(400)
+		h.alertDialog(w, r, "Invalid username parameter", "/#/users")
+		return
+	}
+
+	if err := h.db.ClearTotpSecret(username); err != nil {
+		w.WriteHeader(500)
+		h.alertDialog(w, r, err.Error(), "/#/users")
+		return
+	}
+
+	http.Redirect(w, r, "/#/users", 303)
+}
+
+// handleTunnelLog shows the last tunnelLogMaxLines proxied requests for
+// domain, so a tunnel's owner can debug it without shelling into the
+// server.
+func (h *WebUiHandler) handleTunnelLog(w http.ResponseWriter, r *http.Request, tokenData TokenData, domain string) {
+
+	if r.Method != "GET" {
+		w.WriteHeader(405)
+		h.alertDialog(w, r, "Invalid method for tunnel log", "/#/tunnels")
+		return
+	}
+
+	if _, err := h.api.GetTunnel(tokenData, url.Values{"domain": {domain}}); err != nil {
+		w.WriteHeader(404)
+		h.alertDialog(w, r, "Unknown tunnel", "/#/tunnels")
+		return
+	}
+
+	tmpl, err := h.loadTemplate("tunnel-log.tmpl")
+	if err != nil {
+		w.WriteHeader(500)
+		h.alertDialog(w, r, err.Error(), "/#/tunnels")
+		return
+	}
+
+	data := &TunnelLogData{
+		Head:    h.renderHead(r),
+		Domain:  domain,
+		Entries: h.tunnelLogs.Last(domain),
+	}
+
+	tmpl.Execute(w, data)
+}
+
+func (h *WebUiHandler) confirmDeleteSshKey(w http.ResponseWriter, r *http.Request) {
+
+	r.ParseForm()
+
+	if len(r.Form["id"]) != 1 {
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid id parameter"))
+		return
+	}
+	id := r.Form["id"][0]
+
+	tmpl, err := h.loadTemplate("confirm.tmpl")
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, err.Error())
+		return
+	}
+
+	csrfToken := h.currentCsrfToken(r)
+	data := &ConfirmData{
+		Head:       h.renderHead(r),
+		Message:    fmt.Sprintf("Are you sure you want to delete SSH key %s?", id),
+		ConfirmUrl: fmt.Sprintf("/delete-ssh-key?id=%s&csrf_token=%s", id, csrfToken),
+		CancelUrl:  "/#/ssh-keys",
+		CsrfToken:  csrfToken,
+	}
+
+	tmpl.Execute(w, data)
+}
+
 func (h *WebUiHandler) alertDialog(w http.ResponseWriter, r *http.Request, message, redirectUrl string) error {
 	tmpl, err := h.loadTemplate("alert.tmpl")
 	if err != nil {
@@ -663,7 +1004,7 @@ func (h *WebUiHandler) alertDialog(w http.ResponseWriter, r *http.Request, messa
 	}
 
 	tmpl.Execute(w, &AlertData{
-		Head:        h.headHtml,
+		Head:        h.renderHead(r),
 		Message:     message,
 		RedirectUrl: redirectUrl,
 	})
@@ -671,31 +1012,100 @@ func (h *WebUiHandler) alertDialog(w http.ResponseWriter, r *http.Request, messa
 	return nil
 }
 
-func (h *WebUiHandler) handleLoading(w http.ResponseWriter, r *http.Request) {
+func (h *WebUiHandler) handleLoading(w http.ResponseWriter, r *http.Request, tokenData TokenData) {
 
 	if r.Method != "GET" {
 		w.WriteHeader(405)
-		h.alertDialog(w, r, "Invalid method for users", "/#/tunnels")
+		h.alertDialog(w, r, "Invalid method for /loading", "/#/tunnels")
+		return
 	}
 
 	r.ParseForm()
 
-	pendingId := r.Form.Get("id")
+	jobId := r.Form.Get("id")
 
-	h.mutex.Lock()
-	doneSignal := h.pendingRequests[pendingId]
-	delete(h.pendingRequests, pendingId)
-	h.mutex.Unlock()
+	if _, err := h.jobs.Get(jobId, tokenData.Owner); err != nil {
+		w.WriteHeader(404)
+		h.alertDialog(w, r, "Unknown or expired job", "/#/tunnels")
+		return
+	}
 
-	result := <-doneSignal
+	tmpl, err := h.loadTemplate("loading.tmpl")
+	if err != nil {
+		w.WriteHeader(500)
+		h.alertDialog(w, r, err.Error(), "/#/tunnels")
+		return
+	}
 
-	if result.err != nil {
-		w.WriteHeader(400)
-		h.alertDialog(w, r, result.err.Error(), result.redirectUrl)
+	data := &LoadingData{
+		Head:      h.renderHead(r),
+		TargetUrl: "/#/tunnels",
+		EventsUrl: fmt.Sprintf("/jobs/%s/events", jobId),
+		CsrfToken: h.currentCsrfToken(r),
+	}
+
+	tmpl.Execute(w, data)
+}
+
+// handleJobEvents streams a Job's progress to the client as
+// Server-Sent Events: a replay of everything emitted so far, then live
+// updates, with a heartbeat comment every jobHeartbeat so idle
+// connections aren't reaped by intermediate proxies.
+func (h *WebUiHandler) handleJobEvents(w http.ResponseWriter, r *http.Request, tokenData TokenData, jobId string) {
+
+	job, err := h.jobs.Get(jobId, tokenData.Owner)
+	if err != nil {
+		w.WriteHeader(404)
+		io.WriteString(w, "Unknown job")
 		return
 	}
 
-	http.Redirect(w, r, result.redirectUrl, 303)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(500)
+		io.WriteString(w, "Streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+
+	ch, history := job.subscribe()
+	defer job.unsubscribe(ch)
+
+	writeEvent := func(e Event) {
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", time.Now().UnixNano(), e.Name, e.Data)
+		flusher.Flush()
+	}
+
+	for _, e := range history {
+		writeEvent(e)
+	}
+
+	heartbeat := time.NewTicker(jobHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			writeEvent(e)
+
+			if e.Name == "done" {
+				return
+			}
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
 }
 
 func (h *WebUiHandler) loadTemplate(name string) (*template.Template, error) {
@@ -712,3 +1122,74 @@ func (h *WebUiHandler) loadTemplate(name string) (*template.Template, error) {
 
 	return tmpl, nil
 }
+
+// renderHead renders the shared <head> markup for r's session, embedding
+// the current CSRF token so it's available to any inline script that
+// needs to attach it to fetch()/XHR requests.
+func (h *WebUiHandler) renderHead(r *http.Request) template.HTML {
+	var builder strings.Builder
+	h.headTmpl.Execute(&builder, HeadData{Styles: h.styles, CsrfToken: h.currentCsrfToken(r)})
+	return template.HTML(builder.String())
+}
+
+// currentCsrfToken returns the raw CSRF token for r's session, or "" if
+// no valid csrf_token cookie is present.
+func (h *WebUiHandler) currentCsrfToken(r *http.Request) string {
+	token, err := h.csrf.TokenFromCookie(csrfCookieValue(r))
+	if err != nil {
+		return ""
+	}
+
+	return token
+}
+
+// csrfCookieValue returns the raw csrf_token cookie value from r, or ""
+// if it isn't set.
+func csrfCookieValue(r *http.Request) string {
+	cookie, err := r.Cookie("csrf_token")
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+// ensureCsrfCookie makes sure r carries a valid, signed csrf_token
+// cookie, issuing a fresh one if it's missing or tampered with, and
+// returns the raw token to embed in forms for this request.
+func (h *WebUiHandler) ensureCsrfCookie(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie("csrf_token"); err == nil {
+		if token, err := h.csrf.TokenFromCookie(cookie.Value); err == nil {
+			return token, nil
+		}
+	}
+
+	cookieValue, token, err := h.csrf.GenerateCookieValue()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_token",
+		Value:    cookieValue,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		MaxAge:   86400 * 365,
+	})
+
+	// Reflect the freshly issued cookie onto r so the rest of this
+	// request sees it via csrfCookieValue/currentCsrfToken. Any invalid
+	// csrf_token cookie is dropped first, since r.Cookie returns the
+	// first match.
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != "csrf_token" {
+			r.AddCookie(c)
+		}
+	}
+	r.AddCookie(&http.Cookie{Name: "csrf_token", Value: cookieValue})
+
+	return token, nil
+}